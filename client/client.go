@@ -0,0 +1,118 @@
+package client
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// X_FLASHLIGHT_QOS is the header by which a request can ask to only be
+// served by servers meeting a minimum quality of service.
+const X_FLASHLIGHT_QOS = "X-Flashlight-QOS"
+
+// server is a candidate upstream, selected by Client.randomServer using a
+// smoothed weighted round-robin.
+type server struct {
+	weight int
+	qos    int
+
+	mutex           sync.Mutex
+	currentWeight   int
+	effectiveWeight int
+}
+
+// MarkFailed penalizes s for an observed failure by shrinking its
+// effectiveWeight, making it less likely to be picked until it recovers.
+// effectiveWeight never drops below 1, so a server always has some chance
+// of being retried.
+func (s *server) MarkFailed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.effectiveWeight > 1 {
+		s.effectiveWeight--
+	}
+}
+
+// MarkSucceeded lets s recover towards its configured weight after an
+// observed success.
+func (s *server) MarkSucceeded() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.effectiveWeight < s.weight {
+		s.effectiveWeight++
+	}
+}
+
+// Client selects among a weighted pool of servers for each outgoing
+// request.
+type Client struct {
+	servers            []*server
+	totalServerWeights int
+	mutex              sync.Mutex
+}
+
+// randomServer selects a server for req using an Nginx-style smoothed
+// weighted round-robin: candidates are filtered to those meeting req's
+// requested QOS floor (falling back to the full pool if none qualify),
+// then the candidate with the highest currentWeight after adding its
+// effectiveWeight wins, and total candidate weight is subtracted back out
+// of the winner. This spreads picks evenly across a burst of requests
+// while still letting weight dominate over the long run, and lets a
+// temporarily-penalized high-weight server yield to a healthy low-weight
+// one.
+func (c *Client) randomServer(req *http.Request) *server {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.servers) == 0 {
+		return nil
+	}
+
+	requestedQOS := requestedQOS(req)
+	candidates := make([]*server, 0, len(c.servers))
+	for _, s := range c.servers {
+		if s.qos >= requestedQOS {
+			candidates = append(candidates, s)
+		}
+	}
+	if len(candidates) == 0 {
+		// Nothing meets the requested QOS floor; fall back to the full
+		// pool rather than failing the request outright.
+		candidates = c.servers
+	}
+
+	var total int
+	var best *server
+	for _, s := range candidates {
+		s.mutex.Lock()
+		if s.effectiveWeight == 0 {
+			s.effectiveWeight = s.weight
+		}
+		s.currentWeight += s.effectiveWeight
+		total += s.effectiveWeight
+		if best == nil || s.currentWeight > best.currentWeight {
+			best = s
+		}
+		s.mutex.Unlock()
+	}
+
+	best.mutex.Lock()
+	best.currentWeight -= total
+	best.mutex.Unlock()
+
+	return best
+}
+
+// requestedQOS extracts the QOS floor requested by req, defaulting to 0
+// (no floor) if unset or unparseable.
+func requestedQOS(req *http.Request) int {
+	qosString := req.Header.Get(X_FLASHLIGHT_QOS)
+	if qosString == "" {
+		return 0
+	}
+	qos, err := strconv.Atoi(qosString)
+	if err != nil {
+		return 0
+	}
+	return qos
+}
@@ -46,14 +46,16 @@ func TestRandomServer(t *testing.T) {
 		1500: 0,
 	}
 
-	// Do a bunch of random trials
-	for i := 0; i < 3000; i++ {
+	const iterations = 3000
+	for i := 0; i < iterations; i++ {
 		srv := client.randomServer(req)
 		freqs[srv.weight] = freqs[srv.weight] + 1
 	}
 
 	for weight, freq := range freqs {
-		if freq < float32(weight)-100 || freq > float32(weight)+100 {
+		expected := float32(weight) / float32(client.totalServerWeights) * iterations
+		tolerance := expected * 0.05
+		if freq < expected-tolerance || freq > expected+tolerance {
 			t.Errorf("At QOS 0, weight %d was found an incorrect number of times: %f", weight, freq)
 		}
 	}
@@ -65,8 +67,9 @@ func TestRandomServer(t *testing.T) {
 	}
 
 	req.Header.Set(X_FLASHLIGHT_QOS, "5")
-	// Do a bunch of random trials
-	for i := 0; i < 2500; i++ {
+	const qos5Iterations = 2500
+	const qos5TotalWeight = 1000 + 1500
+	for i := 0; i < qos5Iterations; i++ {
 		srv := client.randomServer(req)
 		freqs[srv.weight] = freqs[srv.weight] + 1
 	}
@@ -77,9 +80,29 @@ func TestRandomServer(t *testing.T) {
 				t.Errorf("At QOS 5, weight 500 should not have ever been found")
 			}
 		} else {
-			if freq < float32(weight)-200 || freq > float32(weight)+200 {
+			expected := float32(weight) / float32(qos5TotalWeight) * qos5Iterations
+			tolerance := expected * 0.05
+			if freq < expected-tolerance || freq > expected+tolerance {
 				t.Errorf("At QOS 5, weight %d was found an incorrect number of times: %f", weight, freq)
 			}
 		}
 	}
 }
+
+func TestMarkFailedAndSucceeded(t *testing.T) {
+	s := &server{weight: 10, effectiveWeight: 10}
+
+	for i := 0; i < 20; i++ {
+		s.MarkFailed()
+	}
+	if s.effectiveWeight != 1 {
+		t.Errorf("effectiveWeight should clamp at 1, got %d", s.effectiveWeight)
+	}
+
+	for i := 0; i < 20; i++ {
+		s.MarkSucceeded()
+	}
+	if s.effectiveWeight != s.weight {
+		t.Errorf("effectiveWeight should recover to weight %d, got %d", s.weight, s.effectiveWeight)
+	}
+}
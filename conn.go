@@ -0,0 +1,156 @@
+package enproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+var connIdCounter uint64
+
+func newConnId() string {
+	return fmt.Sprintf("enproxy-%d", atomic.AddUint64(&connIdCounter, 1))
+}
+
+// NewConn creates a Conn that tunnels traffic to addr using
+// config.transport(), and starts the background goroutines that pump
+// Write/Read calls through it. The returned Conn is ready to use as a
+// net.Conn.
+func NewConn(addr string, config *Config) *Conn {
+	c := &Conn{
+		Addr:            addr,
+		Config:          config,
+		id:              newConnId(),
+		proxyHostCh:     make(chan string, 1),
+		writeRequestsCh: make(chan writeRequest),
+		stopWriteCh:     make(chan interface{}, 1),
+		readRequestsCh:  make(chan readRequest),
+		stopReadCh:      make(chan interface{}, 1),
+		stopReqCh:       make(chan interface{}, 1),
+	}
+	go c.pump()
+	return c
+}
+
+// submitWrite hands b off to the goroutine pumping this Conn's Transport
+// stream and reports whether the Conn was still accepting writes when it
+// did. The actual write happens asynchronously; its result is delivered on
+// respCh, which the caller owns and may safely stop listening to at any
+// time (it's buffered, so the delivery itself never blocks).
+func (c *Conn) submitWrite(b []byte, respCh chan rwResponse) bool {
+	c.writeMutex.RLock()
+	defer c.writeMutex.RUnlock()
+	if c.doneWriting {
+		return false
+	}
+	c.writeRequestsCh <- writeRequest{b, respCh}
+	return true
+}
+
+// submitRead is the Read analogue of submitWrite.
+func (c *Conn) submitRead(b []byte, respCh chan rwResponse) bool {
+	c.readMutex.RLock()
+	defer c.readMutex.RUnlock()
+	if c.doneReading {
+		return false
+	}
+	c.readRequestsCh <- readRequest{b, respCh}
+	return true
+}
+
+// pump establishes this Conn's upstream stream via c.Config.transport() and
+// hands the result (stream, or the dial error) to processWrites and
+// processReads, which run for the Conn's whole lifetime. It's what makes
+// Config.Transport (or the DialProxy/NewRequest pair it falls back to)
+// actually get used.
+func (c *Conn) pump() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type dialResult struct {
+		stream io.ReadWriteCloser
+		err    error
+	}
+	resultCh := make(chan dialResult, 1)
+	go func() {
+		stream, err := c.Config.transport().RoundTripStream(ctx, c.id, c.Addr)
+		resultCh <- dialResult{stream, err}
+	}()
+
+	var stream io.ReadWriteCloser
+	var err error
+	select {
+	case r := <-resultCh:
+		stream, err = r.stream, r.err
+	case <-c.stopReqCh:
+		err = errors.New("enproxy: conn closed before upstream connected")
+	}
+	if stream != nil {
+		defer stream.Close()
+	}
+
+	go c.processWrites(stream, err)
+	c.processReads(stream, err)
+}
+
+// processWrites serializes writeRequestsCh onto stream, delivering each
+// result on the request's own respCh, until stopWriteCh/stopReqCh fires or
+// a write fails. If dialErr is set (stream never came up), every request is
+// immediately failed with it instead. Because respCh is always buffered,
+// this never blocks on a caller that's stopped listening (e.g. because its
+// deadline already fired) - unlike a shared response channel, an abandoned
+// request can't wedge the next one.
+func (c *Conn) processWrites(stream io.ReadWriteCloser, dialErr error) {
+	defer func() {
+		c.writeMutex.Lock()
+		c.doneWriting = true
+		c.writeMutex.Unlock()
+	}()
+	for {
+		select {
+		case req := <-c.writeRequestsCh:
+			if dialErr != nil {
+				req.respCh <- rwResponse{0, dialErr}
+				continue
+			}
+			n, err := stream.Write(req.b)
+			req.respCh <- rwResponse{n, err}
+			if err != nil {
+				return
+			}
+		case <-c.stopWriteCh:
+			return
+		case <-c.stopReqCh:
+			return
+		}
+	}
+}
+
+// processReads is the Read analogue of processWrites.
+func (c *Conn) processReads(stream io.ReadWriteCloser, dialErr error) {
+	defer func() {
+		c.readMutex.Lock()
+		c.doneReading = true
+		c.readMutex.Unlock()
+	}()
+	for {
+		select {
+		case req := <-c.readRequestsCh:
+			if dialErr != nil {
+				req.respCh <- rwResponse{0, dialErr}
+				continue
+			}
+			n, err := stream.Read(req.b)
+			req.respCh <- rwResponse{n, err}
+			if err != nil {
+				return
+			}
+		case <-c.stopReadCh:
+			return
+		case <-c.stopReqCh:
+			return
+		}
+	}
+}
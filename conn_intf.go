@@ -4,6 +4,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 )
@@ -74,20 +75,18 @@ type Conn struct {
 	id string
 
 	/* Channels for processing reads, writes and closes */
-	writeRequestsCh  chan []byte     // requests to write
-	writeResponsesCh chan rwResponse // responses for writes
-	stopWriteCh      chan interface{}
-	doneWriting      bool
-	writeMutex       sync.RWMutex
-	readRequestsCh   chan []byte     // requests to read
-	readResponsesCh  chan rwResponse // responses for reads
-	stopReadCh       chan interface{}
-	doneReading      bool
-	readMutex        sync.RWMutex
-	reqOutCh         chan *io.PipeReader // channel for next outgoing request body
-	stopReqCh        chan interface{}
-	doneRequesting   bool
-	requestMutex     sync.RWMutex
+	writeRequestsCh chan writeRequest // requests to write
+	stopWriteCh     chan interface{}
+	doneWriting     bool
+	writeMutex      sync.RWMutex
+	readRequestsCh  chan readRequest // requests to read
+	stopReadCh      chan interface{}
+	doneReading     bool
+	readMutex       sync.RWMutex
+	reqOutCh        chan *io.PipeReader // channel for next outgoing request body
+	stopReqCh       chan interface{}
+	doneRequesting  bool
+	requestMutex    sync.RWMutex
 
 	/* Fields for tracking activity/closed status */
 	lastActivityTime  time.Time    // time of last read or write
@@ -95,6 +94,12 @@ type Conn struct {
 	closed            bool         // whether or not this Conn is closed
 	closedMutex       sync.RWMutex // mutex controlling access to closed flag
 
+	/* Fields for tracking read/write deadlines */
+	readDeadline       time.Time    // deadline for Reads that begin from now on
+	readDeadlineMutex  sync.RWMutex // mutex controlling access to readDeadline
+	writeDeadline      time.Time    // deadline for Writes that begin from now on
+	writeDeadlineMutex sync.RWMutex // mutex controlling access to writeDeadline
+
 	/* Fields for tracking current request and response */
 	reqBodyWriter *io.PipeWriter // pipe writer to current request body
 	resp          *http.Response // the current response being used to read data
@@ -110,14 +115,45 @@ type rwResponse struct {
 	err error
 }
 
+// writeRequest is a request to write b, along with the channel its result
+// should be delivered on. respCh is always buffered with capacity 1, so
+// processWrites can always deliver a result without blocking even if the
+// Write call that submitted the request has already given up on it (e.g.
+// because its deadline fired) - an abandoned request's result is simply
+// never read, rather than wedging the pipe for the next one.
+type writeRequest struct {
+	b      []byte
+	respCh chan rwResponse
+}
+
+// readRequest is the Read analogue of writeRequest.
+type readRequest struct {
+	b      []byte
+	respCh chan rwResponse
+}
+
 // Config configures a Conn
 type Config struct {
 	// DialProxy: function to open a connection to the proxy
+	//
+	// Deprecated: set Transport to the result of NewPollTransport(DialProxy,
+	// NewRequest) instead.
 	DialProxy dialFunc
 
 	// NewRequest: function to create a new request to the proxy
+	//
+	// Deprecated: set Transport to the result of NewPollTransport(DialProxy,
+	// NewRequest) instead.
 	NewRequest newRequestFunc
 
+	// Transport selects the upstream used to carry this Conn's traffic to
+	// its destination. If nil and DialProxy/NewRequest are set, a
+	// NewPollTransport built from them is used. That transport reads with
+	// GET requests as documented above, but sends each Write as its own POST
+	// rather than batching writes until IdleInterval passes, and it does not
+	// pin the proxy host reported via X_HTTPCONN_PROXY_HOST.
+	Transport Transport
+
 	// IdleInterval: how long to let the write idle before writing out a
 	// request to the proxy.  Defaults to 15 milliseconds.
 	IdleInterval time.Duration
@@ -128,6 +164,16 @@ type Config struct {
 	IdleTimeout time.Duration
 }
 
+// transport returns the Transport this Config should use, falling back to
+// a poll transport built from DialProxy/NewRequest for backwards
+// compatibility when Transport isn't set.
+func (c *Config) transport() Transport {
+	if c.Transport != nil {
+		return c.Transport
+	}
+	return NewPollTransport(c.DialProxy, c.NewRequest)
+}
+
 // LocalAddr() is not implemented
 func (c *Conn) LocalAddr() net.Addr {
 	panic("LocalAddr() not implemented")
@@ -140,30 +186,87 @@ func (c *Conn) RemoteAddr() net.Addr {
 
 // Write() implements the function from net.Conn
 func (c *Conn) Write(b []byte) (n int, err error) {
-	if c.submitWrite(b) {
-		res, ok := <-c.writeResponsesCh
+	timerCh, stop, expired := c.deadlineTimer(c.getWriteDeadline())
+	if expired {
+		return 0, os.ErrDeadlineExceeded
+	}
+	defer stop()
+
+	// submitWrite itself can block indefinitely (e.g. if the goroutine
+	// draining writeRequestsCh is backed up), so race it against the same
+	// timer rather than only bounding the wait for a response below.
+	// respCh is this call's own buffered channel, so if we give up on it
+	// here (deadline/EOF), processWrites can still deliver its eventual
+	// result without blocking - it's just never read.
+	respCh := make(chan rwResponse, 1)
+	submitted := make(chan bool, 1)
+	go func() { submitted <- c.submitWrite(b, respCh) }()
+
+	select {
+	case ok := <-submitted:
 		if !ok {
 			return 0, io.EOF
-		} else {
-			return res.n, res.err
 		}
-	} else {
-		return 0, io.EOF
+	case <-timerCh:
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	select {
+	case res := <-respCh:
+		return res.n, res.err
+	case <-timerCh:
+		return 0, os.ErrDeadlineExceeded
 	}
 }
 
 // Read() implements the function from net.Conn
 func (c *Conn) Read(b []byte) (n int, err error) {
-	if c.submitRead(b) {
-		res, ok := <-c.readResponsesCh
+	timerCh, stop, expired := c.deadlineTimer(c.getReadDeadline())
+	if expired {
+		return 0, os.ErrDeadlineExceeded
+	}
+	defer stop()
+
+	// See the equivalent comment in Write: submitRead can block just as
+	// long as the response it's waiting on, so it needs to race the same
+	// timer too, and respCh is this call's own buffered channel for the
+	// same reason.
+	respCh := make(chan rwResponse, 1)
+	submitted := make(chan bool, 1)
+	go func() { submitted <- c.submitRead(b, respCh) }()
+
+	select {
+	case ok := <-submitted:
 		if !ok {
 			return 0, io.EOF
-		} else {
-			return res.n, res.err
 		}
-	} else {
-		return 0, io.EOF
+	case <-timerCh:
+		return 0, os.ErrDeadlineExceeded
+	}
+
+	select {
+	case res := <-respCh:
+		return res.n, res.err
+	case <-timerCh:
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// deadlineTimer returns a channel that fires once deadline has passed,
+// along with a func to stop it once it's no longer needed. If deadline is
+// the zero value, there's no deadline and timerCh never fires. If deadline
+// has already passed, expired is true and the other return values are
+// meaningless.
+func (c *Conn) deadlineTimer(deadline time.Time) (timerCh <-chan time.Time, stop func(), expired bool) {
+	if deadline.IsZero() {
+		return nil, func() {}, false
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return nil, func() {}, true
 	}
+	timer := time.NewTimer(remaining)
+	return timer.C, func() { timer.Stop() }, false
 }
 
 // Close() implements the function from net.Conn
@@ -185,17 +288,49 @@ func (c *Conn) isClosed() bool {
 	return c.closed
 }
 
-// SetDeadline() is currently unimplemented.
+// SetDeadline sets both the read and write deadlines, as for
+// net.Conn.SetDeadline.
 func (c *Conn) SetDeadline(t time.Time) error {
-	panic("SetDeadline not implemented")
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
 }
 
-// SetReadDeadline() is currently unimplemented.
+// SetReadDeadline sets the deadline for Read calls. Each Read uses the
+// deadline in effect when it begins: it unblocks with os.ErrDeadlineExceeded
+// once that deadline fires, and every Read begun after the deadline has
+// passed fails the same way until a new deadline is set. A Read already
+// pending when the deadline is changed keeps the deadline it began with. A
+// zero value for t clears the deadline.
 func (c *Conn) SetReadDeadline(t time.Time) error {
-	panic("SetReadDeadline not implemented")
+	c.readDeadlineMutex.Lock()
+	defer c.readDeadlineMutex.Unlock()
+	c.readDeadline = t
+	return nil
 }
 
-// SetWriteDeadline() is currently unimplemented.
+// SetWriteDeadline sets the deadline for Write calls. Each Write uses the
+// deadline in effect when it begins: it unblocks with os.ErrDeadlineExceeded
+// once that deadline fires, and every Write begun after the deadline has
+// passed fails the same way until a new deadline is set. A Write already
+// pending when the deadline is changed keeps the deadline it began with. A
+// zero value for t clears the deadline.
 func (c *Conn) SetWriteDeadline(t time.Time) error {
-	panic("SetWriteDeadline not implemented")
+	c.writeDeadlineMutex.Lock()
+	defer c.writeDeadlineMutex.Unlock()
+	c.writeDeadline = t
+	return nil
+}
+
+func (c *Conn) getReadDeadline() time.Time {
+	c.readDeadlineMutex.RLock()
+	defer c.readDeadlineMutex.RUnlock()
+	return c.readDeadline
+}
+
+func (c *Conn) getWriteDeadline() time.Time {
+	c.writeDeadlineMutex.RLock()
+	defer c.writeDeadlineMutex.RUnlock()
+	return c.writeDeadline
 }
@@ -0,0 +1,119 @@
+package enproxy
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestWriteDeadlineExceeded mimics the way http.Server's ReadHeaderTimeout
+// exercises a connection: set a short deadline, then perform an operation
+// that would otherwise block forever, and make sure it unblocks promptly
+// with the documented deadline error.
+func TestWriteDeadlineExceeded(t *testing.T) {
+	conn := &Conn{
+		writeRequestsCh: make(chan writeRequest),
+	}
+
+	// Nothing ever reads writeRequestsCh, so without a deadline this would
+	// block forever.
+	conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+
+	start := time.Now()
+	_, err := conn.Write([]byte("hello"))
+	elapsed := time.Since(start)
+
+	if err != os.ErrDeadlineExceeded {
+		t.Fatalf("Write should have failed with os.ErrDeadlineExceeded, got: %v", err)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("Write should have unblocked soon after the deadline, took: %s", elapsed)
+	}
+}
+
+// TestReadDeadlineExceeded is the Read analogue of TestWriteDeadlineExceeded.
+func TestReadDeadlineExceeded(t *testing.T) {
+	conn := &Conn{
+		readRequestsCh: make(chan readRequest),
+	}
+
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1024))
+	elapsed := time.Since(start)
+
+	if err != os.ErrDeadlineExceeded {
+		t.Fatalf("Read should have failed with os.ErrDeadlineExceeded, got: %v", err)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Fatalf("Read should have unblocked soon after the deadline, took: %s", elapsed)
+	}
+}
+
+// TestWriteDeadlineAlreadyPassed makes sure a Write made after the deadline
+// has already passed fails immediately without attempting to submit.
+func TestWriteDeadlineAlreadyPassed(t *testing.T) {
+	conn := &Conn{
+		writeRequestsCh: make(chan writeRequest),
+	}
+	conn.SetWriteDeadline(time.Now().Add(-time.Second))
+
+	if _, err := conn.Write([]byte("hello")); err != os.ErrDeadlineExceeded {
+		t.Fatalf("Write should have failed with os.ErrDeadlineExceeded, got: %v", err)
+	}
+}
+
+// TestWriteDeadlineDoesNotWedgeSubsequentWrites guards against a regression
+// where an abandoned (timed-out) write would permanently deadlock the Conn
+// once its stale result was finally delivered: because writeRequestsCh and
+// its reply used to be shared across calls, the abandoned reply had
+// nowhere to go once nobody was listening for it anymore, wedging the pipe
+// for every write that came after it. Each write's result now goes to its
+// own buffered channel, so an abandoned reply is simply dropped.
+func TestWriteDeadlineDoesNotWedgeSubsequentWrites(t *testing.T) {
+	conn := &Conn{
+		writeRequestsCh: make(chan writeRequest),
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := conn.Write([]byte("first")); err != os.ErrDeadlineExceeded {
+		t.Fatalf("expected the first Write to time out, got: %v", err)
+	}
+
+	// Simulate processWrites belatedly getting around to the abandoned
+	// first request, then continuing to serve requests normally.
+	go func() {
+		for req := range conn.writeRequestsCh {
+			req.respCh <- rwResponse{len(req.b), nil}
+		}
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write([]byte("second"))
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("second Write should have succeeded, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second Write hung, the Conn was wedged by the abandoned first one")
+	}
+}
+
+// TestSetDeadlineClears makes sure that setting a zero-value deadline
+// clears it, leaving future I/O unbounded.
+func TestSetDeadlineClears(t *testing.T) {
+	conn := &Conn{}
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	conn.SetReadDeadline(time.Time{})
+
+	if !conn.getReadDeadline().IsZero() {
+		t.Fatal("Clearing the read deadline should leave it as the zero value")
+	}
+}
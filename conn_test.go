@@ -0,0 +1,63 @@
+package enproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubTransport hands back a fixed stream and counts how many times
+// RoundTripStream was called, so tests can confirm a Conn actually used
+// the Transport it was configured with.
+type stubTransport struct {
+	stream io.ReadWriteCloser
+	calls  int32
+}
+
+func (t *stubTransport) RoundTripStream(ctx context.Context, connID string, dest string) (io.ReadWriteCloser, error) {
+	atomic.AddInt32(&t.calls, 1)
+	return t.stream, nil
+}
+
+// TestNewConnUsesConfiguredTransport makes sure Conn's Write/Read path
+// actually goes through Config.Transport instead of leaving it unused.
+func TestNewConnUsesConfiguredTransport(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := server.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	stub := &stubTransport{stream: client}
+	conn := NewConn("example.com:80", &Config{Transport: stub})
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("hi")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	buf := make([]byte, 2)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf[:n]) != "hi" {
+		t.Fatalf("expected echoed \"hi\", got %q", buf[:n])
+	}
+
+	if calls := atomic.LoadInt32(&stub.calls); calls != 1 {
+		t.Fatalf("expected Transport.RoundTripStream to be called exactly once, got %d", calls)
+	}
+}
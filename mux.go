@@ -0,0 +1,216 @@
+package enproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Transport carries a single logical stream identified by connID to dest on
+// behalf of a Conn. Implementations are free to multiplex however suits the
+// upstream they talk to - e.g. the historical POST/GET polling scheme, or a
+// single full-duplex WebSocket connection.
+type Transport interface {
+	// RoundTripStream returns a ReadWriteCloser that streams connID's
+	// traffic to/from dest. ctx governs the lifetime of the underlying
+	// upstream request(s); canceling it should unblock any pending I/O.
+	RoundTripStream(ctx context.Context, connID string, dest string) (io.ReadWriteCloser, error)
+}
+
+// RouteMux selects among registered Transports based on a Conn's
+// destination, the way an HTTP mux selects handlers based on a request's
+// path. Routes are matched in the order they were registered with
+// HandleHost; HandleDefault registers the fallback used when no route
+// matches.
+type RouteMux struct {
+	mu       sync.RWMutex
+	routes   []hostRoute
+	fallback Transport
+}
+
+type hostRoute struct {
+	pattern   string
+	transport Transport
+}
+
+// NewRouteMux creates an empty RouteMux. Routes and a default transport
+// must be registered with HandleHost and HandleDefault before use.
+func NewRouteMux() *RouteMux {
+	return &RouteMux{}
+}
+
+// HandleHost registers transport to carry traffic destined for hosts
+// matching pattern, which is either an exact host (with or without a port)
+// or a leading-wildcard like "*.example.com".
+func (m *RouteMux) HandleHost(pattern string, transport Transport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.routes = append(m.routes, hostRoute{pattern, transport})
+}
+
+// HandleDefault registers the transport used when no HandleHost pattern
+// matches a Conn's destination.
+func (m *RouteMux) HandleDefault(transport Transport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fallback = transport
+}
+
+// RoundTripStream implements Transport by delegating to whichever
+// registered transport matches dest.
+func (m *RouteMux) RoundTripStream(ctx context.Context, connID string, dest string) (io.ReadWriteCloser, error) {
+	t := m.match(dest)
+	if t == nil {
+		return nil, fmt.Errorf("enproxy: no transport registered for %s", dest)
+	}
+	return t.RoundTripStream(ctx, connID, dest)
+}
+
+func (m *RouteMux) match(dest string) Transport {
+	host := dest
+	if h, _, err := net.SplitHostPort(dest); err == nil {
+		host = h
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, route := range m.routes {
+		if route.pattern == dest || hostMatches(route.pattern, host) {
+			return route.transport
+		}
+	}
+	return m.fallback
+}
+
+// hostMatches reports whether host satisfies pattern, which is either an
+// exact hostname or a "*.example.com" wildcard matching any subdomain.
+func hostMatches(pattern, host string) bool {
+	if pattern == host {
+		return true
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(host, suffix) && len(host) > len(suffix)
+	}
+	return false
+}
+
+// pollTransport adapts the historical DialProxy/NewRequest pair - sequential
+// POST requests to write, sequential GET requests to read - to the
+// Transport interface, so it can be registered with a RouteMux alongside
+// streaming transports.
+type pollTransport struct {
+	dialProxy  dialFunc
+	newRequest newRequestFunc
+}
+
+// NewPollTransport builds a Transport that carries a stream over HTTP
+// polling using dialProxy and newRequest, for use where a streaming
+// transport (e.g. WebSocket) isn't available. Each Write is sent as its own
+// POST (writes are not batched by IdleInterval), and every request is built
+// with an empty host passed to newRequest.
+func NewPollTransport(dialProxy dialFunc, newRequest newRequestFunc) Transport {
+	return &pollTransport{dialProxy: dialProxy, newRequest: newRequest}
+}
+
+func (t *pollTransport) RoundTripStream(ctx context.Context, connID string, dest string) (io.ReadWriteCloser, error) {
+	return &pollStream{
+		ctx:    ctx,
+		dest:   dest,
+		connID: connID,
+		dial:   t.dialProxy,
+		newReq: t.newRequest,
+	}, nil
+}
+
+// pollStream is a minimal io.ReadWriteCloser that issues one POST per Write
+// and a GET per Read - but, per the package doc comment, only a new GET if
+// one isn't already ongoing; otherwise Read keeps draining the response to
+// the existing GET until it hits EOF, then issues the next one. Once a
+// response carrying the X-HTTPConn-EOF header is exhausted, Read returns
+// io.EOF. Requests are tagged with the X-HTTPConn headers so the proxy can
+// associate them with the same logical connection.
+type pollStream struct {
+	ctx    context.Context
+	dest   string
+	connID string
+	dial   dialFunc
+	newReq newRequestFunc
+
+	mu   sync.Mutex
+	resp *http.Response // response to the GET currently being drained, if any
+	eof  bool           // whether a drained response carried X_HTTPCONN_EOF
+}
+
+func (s *pollStream) Write(b []byte) (int, error) {
+	resp, err := s.roundTrip("POST", strings.NewReader(string(b)))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	return len(b), nil
+}
+
+func (s *pollStream) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.eof {
+			return 0, io.EOF
+		}
+		if s.resp == nil {
+			resp, err := s.roundTrip("GET", nil)
+			if err != nil {
+				return 0, err
+			}
+			s.resp = resp
+		}
+
+		n, err := s.resp.Body.Read(b)
+		if err == io.EOF {
+			s.eof = s.resp.Header.Get(X_HTTPCONN_EOF) != ""
+			s.resp.Body.Close()
+			s.resp = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (s *pollStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resp != nil {
+		s.resp.Body.Close()
+		s.resp = nil
+	}
+	return nil
+}
+
+func (s *pollStream) roundTrip(method string, body io.Reader) (*http.Response, error) {
+	req, err := s.newReq("", method, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(s.ctx)
+	req.Header.Set(X_HTTPCONN_ID, s.connID)
+	req.Header.Set(X_HTTPCONN_DEST_ADDR, s.dest)
+
+	conn, err := s.dial(s.dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(conn), req)
+}
@@ -0,0 +1,128 @@
+package enproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeProxyDial returns a dialFunc that, since pollStream.roundTrip dials a
+// fresh connection per request, hands out the next body in bodies (in
+// order) across successive calls to the returned dialFunc - not per
+// connection, each of which only ever serves one request.
+func fakeProxyDial(bodies []string) dialFunc {
+	var next int32
+	return func(addr string) (net.Conn, error) {
+		i := atomic.AddInt32(&next, 1) - 1
+		if int(i) >= len(bodies) {
+			return nil, fmt.Errorf("fakeProxyDial: no body left for call %d", i)
+		}
+		body := bodies[i]
+
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			req, err := http.ReadRequest(bufio.NewReader(server))
+			if err != nil {
+				return
+			}
+			req.Body.Close()
+			fmt.Fprintf(server, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+		}()
+		return client, nil
+	}
+}
+
+func fakeNewRequest(host string, method string, body io.Reader) (*http.Request, error) {
+	return http.NewRequest(method, "http://"+host+"/", body)
+}
+
+// TestPollStreamReadSpansMultipleReads makes sure a Read smaller than the
+// in-flight GET response's body doesn't throw away the remainder, per the
+// "continue to accept reads, grabbing these from the response of the
+// existing GET request" behavior documented on the enproxy package.
+func TestPollStreamReadSpansMultipleReads(t *testing.T) {
+	transport := NewPollTransport(fakeProxyDial([]string{"hello world"}), fakeNewRequest)
+	stream, err := transport.RoundTripStream(context.Background(), "conn1", "example.com:80")
+	if err != nil {
+		t.Fatalf("RoundTripStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	buf := make([]byte, 5)
+	n, err := stream.Read(buf)
+	if err != nil {
+		t.Fatalf("first Read failed: %v", err)
+	}
+	if got := string(buf[:n]); got != "hello" {
+		t.Fatalf("expected %q, got %q", "hello", got)
+	}
+
+	n, err = stream.Read(buf)
+	if err != nil {
+		t.Fatalf("second Read failed: %v", err)
+	}
+	if got := string(buf[:n]); got != " worl" {
+		t.Fatalf("expected %q, got %q", " worl", got)
+	}
+}
+
+// TestPollStreamReadIssuesNewGETAfterEOF makes sure a fresh GET is only
+// issued once the prior one's body is exhausted, not on every Read.
+func TestPollStreamReadIssuesNewGETAfterEOF(t *testing.T) {
+	transport := NewPollTransport(fakeProxyDial([]string{"ab", "cd"}), fakeNewRequest)
+	stream, err := transport.RoundTripStream(context.Background(), "conn1", "example.com:80")
+	if err != nil {
+		t.Fatalf("RoundTripStream failed: %v", err)
+	}
+	defer stream.Close()
+
+	buf := make([]byte, 2)
+	n, err := stream.Read(buf)
+	if err != nil || string(buf[:n]) != "ab" {
+		t.Fatalf("expected \"ab\", got %q, err %v", buf[:n], err)
+	}
+
+	n, err = stream.Read(buf)
+	if err != nil || string(buf[:n]) != "cd" {
+		t.Fatalf("expected \"cd\" from a new GET, got %q, err %v", buf[:n], err)
+	}
+}
+
+func TestHostMatches(t *testing.T) {
+	cases := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"chat.example.com", "chat.example.com", true},
+		{"chat.example.com", "other.example.com", false},
+		{"*.example.com", "chat.example.com", true},
+		{"*.example.com", "example.com", false},
+	}
+	for _, c := range cases {
+		if got := hostMatches(c.pattern, c.host); got != c.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", c.pattern, c.host, got, c.want)
+		}
+	}
+}
+
+func TestRouteMuxMatchesRegisteredHostThenFallsBackToDefault(t *testing.T) {
+	wanted := NewPollTransport(fakeProxyDial(nil), fakeNewRequest)
+	fallback := NewPollTransport(fakeProxyDial(nil), fakeNewRequest)
+
+	mux := NewRouteMux()
+	mux.HandleHost("*.example.com", wanted)
+	mux.HandleDefault(fallback)
+
+	if mux.match("chat.example.com:443") != wanted {
+		t.Fatal("expected the *.example.com route to match")
+	}
+	if mux.match("other.org:443") != fallback {
+		t.Fatal("expected the unmatched host to fall back to the default transport")
+	}
+}
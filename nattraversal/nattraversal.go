@@ -1,17 +1,24 @@
 package nattraversal
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/getlantern/flashlight/log"
 	"github.com/getlantern/go-natty/natty"
 	"github.com/getlantern/waddell"
+	"github.com/pion/dtls/v2"
 )
 
 const (
@@ -19,23 +26,81 @@ const (
 )
 
 const (
-	MaxMessageSize    = 4096
-	NumUDPTestPackets = 10
-	Ready             = "Ready"
-	Timeout           = 15 * time.Second
+	MaxMessageSize = 4096
+	Timeout        = 15 * time.Second
 )
 
+// Proto identifies which transport a traversal should yield once the
+// five-tuple has been obtained.
+type Proto byte
+
+const (
+	// ProtoUDP hole-punches a UDP flow across the traversed five-tuple. This
+	// is the default and preserves the historical behavior of this package.
+	ProtoUDP Proto = iota
+
+	// ProtoTCP performs a simultaneous-open TCP hole-punch across the
+	// traversed five-tuple, for callers that want a reliable transport.
+	ProtoTCP
+)
+
+// messageKind distinguishes the natty handshake relay from our own signaling
+// pieces multiplexed over the same waddell traversal.
+type messageKind byte
+
+const (
+	kindNatty messageKind = iota
+	kindDTLSFingerprint
+)
+
+// DTLSConfig governs the optional DTLS layer wrapped around a UDP
+// hole-punched flow. When nil, the traversal yields the raw UDP conn, wire
+// compatible with peers that don't speak DTLS.
+type DTLSConfig struct {
+	// Certificates are presented during the DTLS handshake. The first
+	// certificate's fingerprint (SHA-256 of the DER bytes) is what gets
+	// pinned over the waddell signaling channel.
+	Certificates []tls.Certificate
+
+	// PSKCallback, if set, switches the handshake to a pre-shared-key
+	// ciphersuite instead of certificate authentication.
+	PSKCallback     func(hint []byte) ([]byte, error)
+	PSKIdentityHint []byte
+
+	// InsecureSkipVerify disables fingerprint pinning. Only for tests.
+	InsecureSkipVerify bool
+}
+
 type Peers map[waddell.PeerId]*Peer
 
 type Peer struct {
 	id              waddell.PeerId
-	traversals      map[uint32]*natty.Traversal
+	traversals      map[uint32]*traversal
 	traversalsMutex sync.Mutex
 }
 
+// traversal tracks the natty.Traversal backing a single hole-punch attempt,
+// the Proto and DTLSConfig negotiated for it, and the peer's pinned
+// certificate fingerprint once it arrives over waddell.
+type traversal struct {
+	t             *natty.Traversal
+	proto         Proto
+	isOffering    bool
+	dtlsConfig    *DTLSConfig
+	fingerprintCh chan []byte
+}
+
 type PeerConfig struct {
 	Id          string
 	WaddellAddr string
+
+	// Proto is the transport to hole-punch once the five-tuple has been
+	// obtained. Defaults to ProtoUDP.
+	Proto Proto
+
+	// DTLSConfig, if set, wraps the resulting UDP flow in a mutually
+	// authenticated DTLS session. Only meaningful with Proto == ProtoUDP.
+	DTLSConfig *DTLSConfig
 }
 
 type WaddellConn struct {
@@ -43,18 +108,31 @@ type WaddellConn struct {
 	conn   net.Conn
 }
 
+// message wraps a traversalId, Proto and messageKind (the "header") around
+// the data exchanged over waddell, so the answerer learns which transport
+// the offerer wants and can tell DTLS signaling apart from natty relay data.
 type message []byte
 
-func (msg message) setTraversalId(id uint32) {
-	endianness.PutUint32(msg[:4], id)
-}
-
 func (msg message) getTraversalId() uint32 {
 	return endianness.Uint32(msg[:4])
 }
 
-func (msg message) getData() []byte {
-	return msg[4:]
+func (msg message) getProto() Proto {
+	return Proto(msg[4])
+}
+
+// getKind is only meaningful for messages sent with the 6-byte withKind
+// header; callers must already know from their own *traversal's dtlsConfig
+// whether to expect it.
+func (msg message) getKind() messageKind {
+	return messageKind(msg[5])
+}
+
+func (msg message) getData(withKind bool) []byte {
+	if withKind {
+		return msg[6:]
+	}
+	return msg[5:]
 }
 
 var (
@@ -63,7 +141,9 @@ var (
 	peers        Peers
 	peersMutex   sync.Mutex
 	debugOut     io.Writer
-	serverReady  = make(chan bool, NumUDPTestPackets)
+
+	listenersMutex sync.Mutex
+	listeners      = make(map[string]map[Proto]*Listener)
 )
 
 func init() {
@@ -72,9 +152,23 @@ func init() {
 	//debugOut = os.Stderr
 }
 
-func idToBytes(id uint32) []byte {
-	b := make([]byte, 4)
+// header builds the piece sent as the first part of every waddell message
+// for a traversal: the original 5-byte (traversalId, proto) header when
+// withKind is false, which is wire-compatible with peers that predate DTLS
+// support, or a 6-byte (traversalId, proto, kind) header when withKind is
+// true, letting the two sides of a DTLS-enabled traversal tell fingerprint
+// pinning messages apart from natty relay data.
+func header(id uint32, proto Proto, kind messageKind, withKind bool) []byte {
+	n := 5
+	if withKind {
+		n = 6
+	}
+	b := make([]byte, n)
 	endianness.PutUint32(b[:4], id)
+	b[4] = byte(proto)
+	if withKind {
+		b[5] = byte(kind)
+	}
 	return b
 }
 
@@ -98,6 +192,9 @@ func ConnectToWaddell(waddellAddr string) (err error, wc *WaddellConn) {
 	return
 }
 
+// CheckPeersList makes sure we have an open waddell connection to, and an
+// outstanding hole-punch to, every configured peer, using each peer's
+// configured Proto and DTLSConfig.
 func CheckPeersList(configPeers *[]PeerConfig) {
 	for _, peer := range *configPeers {
 		peerId, err := waddell.PeerIdFromString(peer.Id)
@@ -115,25 +212,149 @@ func CheckPeersList(configPeers *[]PeerConfig) {
 			ConnectToWaddell(peer.WaddellAddr)
 		}
 
-		log.Debugf("Sending offer to peer %s", peer.Id)
-		sendOffer(peer.WaddellAddr, peerId)
+		go func(peer PeerConfig, peerId waddell.PeerId) {
+			log.Debugf("Sending offer to peer %s", peer.Id)
+			conn, err := Dial(peer.WaddellAddr, peerId, peer.Proto, peer.DTLSConfig)
+			if err != nil {
+				log.Errorf("Unable to hole-punch to peer %s: %s", peer.Id, err)
+				return
+			}
+			conn.Close()
+		}(peer, peerId)
 	}
 }
 
-func sendMessages(wc *WaddellConn, t *natty.Traversal, peerId waddell.PeerId,
-	traversalId uint32) {
+// Dial hole-punches a connection to peerId over waddellAddr using proto,
+// blocking until the five-tuple has been traversed and the resulting
+// transport (optionally wrapped in DTLS, per dtlsConfig) has been
+// established, or an error occurs.
+func Dial(waddellAddr string, peerId waddell.PeerId, proto Proto, dtlsConfig *DTLSConfig) (net.Conn, error) {
+	wc := WaddellConns[waddellAddr]
+	if wc == nil {
+		return nil, fmt.Errorf("Not connected to waddell at %s", waddellAddr)
+	}
+
+	traversalId := uint32(rand.Int31())
+	log.Debugf("Starting traversal: %d", traversalId)
+
+	tv := &traversal{
+		t:             natty.Offer(debugOut),
+		proto:         proto,
+		isOffering:    true,
+		dtlsConfig:    dtlsConfig,
+		fingerprintCh: make(chan []byte, 1),
+	}
+
+	p := &Peer{
+		id:         peerId,
+		traversals: make(map[uint32]*traversal),
+	}
+	p.traversals[traversalId] = tv
+	peers[peerId] = p
+
+	sendDTLSFingerprint(wc.client, peerId, traversalId, tv)
+	go sendMessages(wc, peerId, traversalId, tv)
+	go receiveMessages(wc, traversalId, tv)
+
+	ft, err := tv.t.FiveTupleTimeout(Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to offer: %s", err)
+	}
+	log.Debugf("Got five tuple: %s", ft)
+	return establishConn(ft, tv)
+}
+
+// Listen registers a listener that accepts connections resulting from
+// incoming offers hole-punched over waddellAddr using proto, optionally
+// wrapped in DTLS per dtlsConfig.
+func Listen(waddellAddr string, proto Proto, dtlsConfig *DTLSConfig) (net.Listener, error) {
+	listenersMutex.Lock()
+	defer listenersMutex.Unlock()
+
+	byProto := listeners[waddellAddr]
+	if byProto == nil {
+		byProto = make(map[Proto]*Listener)
+		listeners[waddellAddr] = byProto
+	}
+	if byProto[proto] != nil {
+		return nil, fmt.Errorf("Already listening for proto %d traversals on %s", proto, waddellAddr)
+	}
+
+	l := &Listener{
+		waddellAddr: waddellAddr,
+		proto:       proto,
+		dtlsConfig:  dtlsConfig,
+		connCh:      make(chan net.Conn),
+		closeCh:     make(chan struct{}),
+	}
+	byProto[proto] = l
+	return l, nil
+}
+
+// Listener is a net.Listener whose connections arrive as peers successfully
+// hole-punch to us over waddell, rather than from a single bound socket.
+type Listener struct {
+	waddellAddr string
+	proto       Proto
+	dtlsConfig  *DTLSConfig
+	connCh      chan net.Conn
+	closeCh     chan struct{}
+	closeOnce   sync.Once
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, errors.New("nattraversal: listener closed")
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		listenersMutex.Lock()
+		delete(listeners[l.waddellAddr], l.proto)
+		listenersMutex.Unlock()
+		close(l.closeCh)
+	})
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return nil
+}
+
+// sendDTLSFingerprint sends our own certificate fingerprint to the peer, if
+// dtlsConfig is configured with certificates, so they can pin it before our
+// side of the DTLS handshake arrives.
+func sendDTLSFingerprint(client *waddell.Client, peerId waddell.PeerId, traversalId uint32, tv *traversal) {
+	if tv.dtlsConfig == nil || len(tv.dtlsConfig.Certificates) == 0 {
+		return
+	}
+	fingerprint := certFingerprint(tv.dtlsConfig.Certificates[0])
+	client.SendPieces(peerId, header(traversalId, tv.proto, kindDTLSFingerprint, true), fingerprint)
+}
+
+func certFingerprint(cert tls.Certificate) []byte {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return sum[:]
+}
+
+func sendMessages(wc *WaddellConn, peerId waddell.PeerId, traversalId uint32, tv *traversal) {
+	withKind := tv.dtlsConfig != nil
 	for {
-		msgOut, done := t.NextMsgOut()
+		msgOut, done := tv.t.NextMsgOut()
 		if done {
 			return
 		}
 		log.Debugf("Sending %s", msgOut)
-		wc.client.SendPieces(peerId, idToBytes(traversalId), []byte(msgOut))
+		wc.client.SendPieces(peerId, header(traversalId, tv.proto, kindNatty, withKind), []byte(msgOut))
 	}
 }
 
-func receiveMessages(wc *WaddellConn, t *natty.Traversal,
-	traversalId uint32) {
+func receiveMessages(wc *WaddellConn, traversalId uint32, tv *traversal) {
+	withKind := tv.dtlsConfig != nil
 	b := make([]byte, MaxMessageSize+waddell.WADDELL_OVERHEAD)
 	for {
 		wm, err := wc.client.Receive(b)
@@ -145,90 +366,124 @@ func receiveMessages(wc *WaddellConn, t *natty.Traversal,
 			log.Debugf("Got message for unknown traversal %d, skipping", msg.getTraversalId())
 			continue
 		}
-		log.Debugf("Received: %s", msg.getData())
-		msgString := string(msg.getData())
-		if Ready == msgString {
-			// Server's ready!
-			serverReady <- true
-		} else {
-			t.MsgIn(msgString)
+		if withKind && msg.getKind() == kindDTLSFingerprint {
+			log.Debugf("Received peer DTLS fingerprint for traversal %d", traversalId)
+			tv.fingerprintCh <- append([]byte{}, msg.getData(true)...)
+			continue
 		}
+		log.Debugf("Received: %s", msg.getData(withKind))
+		tv.t.MsgIn(string(msg.getData(withKind)))
 	}
 }
 
-func sendOffer(waddellAddr string, peerId waddell.PeerId) {
-	wc := WaddellConns[waddellAddr]
-
-	traversalId := uint32(rand.Int31())
-	log.Debugf("Starting traversal: %d", traversalId)
-
-	t := natty.Offer(debugOut)
-
-	p := &Peer{
-		id:         peerId,
-		traversals: make(map[uint32]*natty.Traversal),
-	}
-	p.traversals[traversalId] = t
-	peers[peerId] = p
-
-	go sendMessages(wc, t, peerId, traversalId)
-	go receiveMessages(wc, t, traversalId)
-
-	ft, err := t.FiveTupleTimeout(Timeout)
-	if err != nil {
-		log.Fatalf("Unable to offer: %s", err)
-	}
-	log.Debugf("Got five tuple: %s", ft)
-	if <-serverReady {
-		writeUDP(ft)
+// establishConn uses the traversed five-tuple to set up the requested
+// transport, wrapping UDP flows in DTLS when tv.dtlsConfig is set.
+func establishConn(ft *natty.FiveTuple, tv *traversal) (net.Conn, error) {
+	switch tv.proto {
+	case ProtoTCP:
+		local, remote, err := ft.TCPAddrs()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve TCP addresses: %s", err)
+		}
+		return dialTCPSimultaneous(local, remote)
+	default:
+		local, remote, err := ft.UDPAddrs()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve UDP addresses: %s", err)
+		}
+		conn, err := net.DialUDP("udp", local, remote)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to dial UDP: %s", err)
+		}
+		if tv.dtlsConfig == nil {
+			return conn, nil
+		}
+		return wrapDTLS(conn, tv)
 	}
 }
 
-func writeUDP(ft *natty.FiveTuple) {
-	local, remote, err := ft.UDPAddrs()
-	if err != nil {
-		log.Fatalf("Unable to resolve UDP addresses: %s", err)
+// wrapDTLS performs a DTLS 1.2 handshake over conn, offerer acting as
+// client and answerer as server, authenticated by the fingerprint pinned
+// over waddell (unless InsecureSkipVerify is set for tests).
+func wrapDTLS(conn *net.UDPConn, tv *traversal) (net.Conn, error) {
+	cfg := &dtls.Config{
+		Certificates: tv.dtlsConfig.Certificates,
+		// We authenticate peers by pinning their certificate fingerprint
+		// over the waddell signaling channel (below), not by a CA chain --
+		// there isn't one for these ephemeral P2P certs. dtls.Config, like
+		// crypto/tls, only consults VerifyPeerCertificate after normal
+		// chain verification has already succeeded, so chain verification
+		// must stay disabled here even in the non-test, pinned case; it's
+		// VerifyPeerCertificate that does the actual authentication.
+		InsecureSkipVerify: true,
 	}
-	conn, err := net.DialUDP("udp", local, remote)
-	if err != nil {
-		log.Fatalf("Unable to dial UDP: %s", err)
+	if tv.dtlsConfig.PSKCallback != nil {
+		cfg.PSK = tv.dtlsConfig.PSKCallback
+		cfg.PSKIdentityHint = tv.dtlsConfig.PSKIdentityHint
 	}
-	for i := 0; i < NumUDPTestPackets; i++ {
-		msg := fmt.Sprintf("Hello from %s to %s", ft.Local, ft.Remote)
-		log.Debugf("Sending UDP message: %s", msg)
-		_, err := conn.Write([]byte(msg))
-		if err != nil {
-			log.Fatalf("Offerer unable to write to UDP: %s", err)
+	if !tv.dtlsConfig.InsecureSkipVerify && len(tv.dtlsConfig.Certificates) > 0 {
+		select {
+		case fingerprint := <-tv.fingerprintCh:
+			cfg.VerifyPeerCertificate = pinnedFingerprintVerifier(fingerprint)
+		case <-time.After(Timeout):
+			conn.Close()
+			return nil, errors.New("Timed out waiting for peer DTLS fingerprint")
 		}
-		time.Sleep(1 * time.Second)
 	}
-	conn.Close()
-}
 
-func readUDP(wc *waddell.Client, peerId waddell.PeerId, traversalId uint32, ft *natty.FiveTuple) {
-	local, _, err := ft.UDPAddrs()
-	if err != nil {
-		log.Fatalf("Unable to resolve UDP addresses: %s", err)
-	}
-	conn, err := net.ListenUDP("udp", local)
-	if err != nil {
-		log.Fatalf("Unable to listen on UDP: %s", err)
+	if tv.isOffering {
+		return dtls.Client(conn, cfg)
 	}
-	log.Debugf("Listening for UDP packets at: %s", local)
-	notifyClientOfServerReady(wc, peerId, traversalId)
-	b := make([]byte, 1024)
-	for {
-		n, addr, err := conn.ReadFrom(b)
-		if err != nil {
-			log.Fatalf("Unable to read from UDP: %s", err)
+	return dtls.Server(conn, cfg)
+}
+
+func pinnedFingerprintVerifier(expected []byte) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate presented")
 		}
-		msg := string(b[:n])
-		log.Debugf("Got UDP message from %s: '%s'", addr, msg)
+		sum := sha256.Sum256(rawCerts[0])
+		if !bytes.Equal(sum[:], expected) {
+			return errors.New("peer certificate fingerprint does not match pinned value")
+		}
+		return nil
 	}
 }
 
-func notifyClientOfServerReady(wc *waddell.Client, peerId waddell.PeerId, traversalId uint32) {
-	wc.SendPieces(peerId, idToBytes(traversalId), []byte(Ready))
+// dialTCPSimultaneous performs a true simultaneous-open TCP hole-punch: both
+// peers connect() from local to remote at roughly the same time, with
+// SO_REUSEADDR/SO_REUSEPORT set on the socket so the local port natty
+// negotiated can be reused without first binding a Listener. Mixing
+// Listen+Dial on the same local port (as an earlier version of this
+// function did) doesn't work: net.ListenTCP sets SO_REUSEADDR but
+// net.DialTCP never does, so whichever side binds second gets
+// EADDRINUSE almost every time instead of letting both SYNs cross in
+// flight the way simultaneous-open requires.
+func dialTCPSimultaneous(local, remote *net.TCPAddr) (net.Conn, error) {
+	dialer := &net.Dialer{
+		LocalAddr: local,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = setSockoptReusable(fd)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	deadline := time.Now().Add(Timeout)
+	var lastErr error
+	for time.Now().Before(deadline) {
+		conn, err := dialer.Dial("tcp", remote.String())
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		time.Sleep(100 * time.Millisecond)
+	}
+	return nil, fmt.Errorf("Timed out dialing %s from %s: %s", remote, local, lastErr)
 }
 
 func ReceiveOffers(waddellAddr string) {
@@ -246,10 +501,10 @@ func ReceiveOffers(waddellAddr string) {
 			}
 			continue
 		}
-		msg := []byte(wm.Body)
+		msg := message(wm.Body)
 		log.Debugf("Peer ID is %s", wm.From.String())
-		log.Debugf("Received waddell message: %s", msg[4:])
-		answer(wc.client, wm)
+		log.Debugf("Received waddell message: %s", msg.getData(false))
+		answer(wc.client, waddellAddr, wm)
 	}
 }
 
@@ -264,39 +519,59 @@ func CloseWaddellConn(waddellAddr string) {
 	}
 }
 
-func answer(wc *waddell.Client, wm *waddell.Message) {
+func answer(wc *waddell.Client, waddellAddr string, wm *waddell.Message) {
 	peersMutex.Lock()
 	defer peersMutex.Unlock()
 	p := peers[wm.From]
 	if p == nil {
 		p = &Peer{
 			id:         wm.From,
-			traversals: make(map[uint32]*natty.Traversal),
+			traversals: make(map[uint32]*traversal),
 		}
 		peers[wm.From] = p
 	}
-	p.answer(wc, wm)
+	p.answer(wc, waddellAddr, wm)
 }
 
-func (p *Peer) answer(wc *waddell.Client, wm *waddell.Message) {
+func (p *Peer) answer(wc *waddell.Client, waddellAddr string, wm *waddell.Message) {
 	p.traversalsMutex.Lock()
 	defer p.traversalsMutex.Unlock()
 	msg := message(wm.Body)
 	traversalId := msg.getTraversalId()
-	t := p.traversals[traversalId]
-	if t == nil {
+	proto := msg.getProto()
+	tv := p.traversals[traversalId]
+	if tv == nil {
 		log.Debugf("Answering traversal: %d", traversalId)
-		// Set up a new Natty traversal
-		t = natty.Answer(debugOut)
+
+		listenersMutex.Lock()
+		l := listeners[waddellAddr][proto]
+		listenersMutex.Unlock()
+		var dtlsConfig *DTLSConfig
+		if l != nil {
+			dtlsConfig = l.dtlsConfig
+		}
+
+		tv = &traversal{
+			t:             natty.Answer(debugOut),
+			proto:         proto,
+			isOffering:    false,
+			dtlsConfig:    dtlsConfig,
+			fingerprintCh: make(chan []byte, 1),
+		}
+		p.traversals[traversalId] = tv
+
+		sendDTLSFingerprint(wc, p.id, traversalId, tv)
+
 		go func() {
 			// Send
+			withKind := tv.dtlsConfig != nil
 			for {
-				msgOut, done := t.NextMsgOut()
+				msgOut, done := tv.t.NextMsgOut()
 				if done {
 					return
 				}
 				log.Debugf("Sending %s", msgOut)
-				wc.SendPieces(p.id, idToBytes(traversalId), []byte(msgOut))
+				wc.SendPieces(p.id, header(traversalId, proto, kindNatty, withKind), []byte(msgOut))
 			}
 		}()
 
@@ -308,17 +583,45 @@ func (p *Peer) answer(wc *waddell.Client, wm *waddell.Message) {
 				delete(p.traversals, traversalId)
 			}()
 
-			ft, err := t.FiveTupleTimeout(Timeout)
+			ft, err := tv.t.FiveTupleTimeout(Timeout)
 			if err != nil {
 				log.Debugf("Unable to answer traversal %d: %s", traversalId, err)
 				return
 			}
 
 			log.Debugf("Got five tuple: %s", ft)
-			go readUDP(wc, p.id, traversalId, ft)
+			conn, err := establishConn(ft, tv)
+			if err != nil {
+				log.Errorf("Unable to establish conn for traversal %d: %s", traversalId, err)
+				return
+			}
+			dispatchAcceptedConn(waddellAddr, proto, conn)
 		}()
-		p.traversals[traversalId] = t
 	}
-	log.Debugf("Received for traversal %d: %s", traversalId, msg.getData())
-	t.MsgIn(string(msg.getData()))
+	withKind := tv.dtlsConfig != nil
+	if withKind && msg.getKind() == kindDTLSFingerprint {
+		log.Debugf("Received peer DTLS fingerprint for traversal %d", traversalId)
+		tv.fingerprintCh <- append([]byte{}, msg.getData(true)...)
+		return
+	}
+	log.Debugf("Received for traversal %d: %s", traversalId, msg.getData(withKind))
+	tv.t.MsgIn(string(msg.getData(withKind)))
+}
+
+// dispatchAcceptedConn hands conn to whoever is Listen()ing for this
+// waddellAddr/proto, closing it if nobody is.
+func dispatchAcceptedConn(waddellAddr string, proto Proto, conn net.Conn) {
+	listenersMutex.Lock()
+	l := listeners[waddellAddr][proto]
+	listenersMutex.Unlock()
+	if l == nil {
+		log.Debugf("No listener for proto %d traversals on %s, dropping connection", proto, waddellAddr)
+		conn.Close()
+		return
+	}
+	select {
+	case l.connCh <- conn:
+	case <-l.closeCh:
+		conn.Close()
+	}
 }
@@ -0,0 +1,86 @@
+package nattraversal
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+// TestHeaderRoundTripWithoutKind covers the legacy 5-byte header used when a
+// traversal has no DTLSConfig: traversalId and proto must round-trip, and
+// getData must return everything after the 5-byte header.
+func TestHeaderRoundTripWithoutKind(t *testing.T) {
+	h := header(12345, ProtoTCP, kindNatty, false)
+	if len(h) != 5 {
+		t.Fatalf("expected a 5-byte header, got %d bytes", len(h))
+	}
+
+	msg := message(append(h, []byte("payload")...))
+	if got := msg.getTraversalId(); got != 12345 {
+		t.Fatalf("getTraversalId() = %d, want 12345", got)
+	}
+	if got := msg.getProto(); got != ProtoTCP {
+		t.Fatalf("getProto() = %v, want %v", got, ProtoTCP)
+	}
+	if got := string(msg.getData(false)); got != "payload" {
+		t.Fatalf("getData(false) = %q, want %q", got, "payload")
+	}
+}
+
+// TestHeaderRoundTripWithKind covers the 6-byte header used once a traversal
+// negotiates DTLS, which adds a kind byte so fingerprint-pinning messages can
+// be told apart from natty relay data.
+func TestHeaderRoundTripWithKind(t *testing.T) {
+	h := header(98765, ProtoUDP, kindDTLSFingerprint, true)
+	if len(h) != 6 {
+		t.Fatalf("expected a 6-byte header, got %d bytes", len(h))
+	}
+
+	msg := message(append(h, []byte("fingerprint-bytes")...))
+	if got := msg.getTraversalId(); got != 98765 {
+		t.Fatalf("getTraversalId() = %d, want 98765", got)
+	}
+	if got := msg.getProto(); got != ProtoUDP {
+		t.Fatalf("getProto() = %v, want %v", got, ProtoUDP)
+	}
+	if got := msg.getKind(); got != kindDTLSFingerprint {
+		t.Fatalf("getKind() = %v, want %v", got, kindDTLSFingerprint)
+	}
+	if got := string(msg.getData(true)); got != "fingerprint-bytes" {
+		t.Fatalf("getData(true) = %q, want %q", got, "fingerprint-bytes")
+	}
+}
+
+// TestPinnedFingerprintVerifierAcceptsMatch makes sure a raw cert whose
+// SHA-256 matches the pinned fingerprint is accepted.
+func TestPinnedFingerprintVerifierAcceptsMatch(t *testing.T) {
+	cert := []byte("pretend this is a DER-encoded certificate")
+	sum := sha256.Sum256(cert)
+
+	verify := pinnedFingerprintVerifier(sum[:])
+	if err := verify([][]byte{cert}, nil); err != nil {
+		t.Fatalf("expected a matching fingerprint to be accepted, got: %v", err)
+	}
+}
+
+// TestPinnedFingerprintVerifierRejectsMismatch guards the actual
+// authentication this verifier exists for: an on-path attacker presenting a
+// different certificate must be rejected, not waved through.
+func TestPinnedFingerprintVerifierRejectsMismatch(t *testing.T) {
+	expected := sha256.Sum256([]byte("the certificate we pinned over waddell"))
+	attacker := []byte("a different certificate presented during the handshake")
+
+	verify := pinnedFingerprintVerifier(expected[:])
+	if err := verify([][]byte{attacker}, nil); err == nil {
+		t.Fatal("expected a mismatched fingerprint to be rejected")
+	}
+}
+
+// TestPinnedFingerprintVerifierRejectsNoCertificate makes sure an empty
+// rawCerts (no certificate presented) is rejected rather than panicking or
+// silently accepting.
+func TestPinnedFingerprintVerifierRejectsNoCertificate(t *testing.T) {
+	verify := pinnedFingerprintVerifier([]byte("doesn't matter"))
+	if err := verify(nil, nil); err == nil {
+		t.Fatal("expected no presented certificate to be rejected")
+	}
+}
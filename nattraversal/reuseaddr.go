@@ -0,0 +1,16 @@
+//go:build !windows
+
+package nattraversal
+
+import "syscall"
+
+// setSockoptReusable marks fd's address as reusable so a simultaneous
+// connect() can bind the same local port a Dialer just picked, without
+// first going through a Listen/Accept that only one of the two peers in a
+// simultaneous-open could ever win.
+func setSockoptReusable(fd uintptr) error {
+	if err := syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+		return err
+	}
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package nattraversal
+
+import "syscall"
+
+// setSockoptReusable is the Windows counterpart of the unix version. Windows
+// has no SO_REUSEPORT; SO_REUSEADDR alone is enough to let the simultaneous
+// connect() in dialTCPSimultaneous reuse the local port a Dialer just picked.
+func setSockoptReusable(fd uintptr) error {
+	return syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+}
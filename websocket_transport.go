@@ -0,0 +1,81 @@
+package enproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketTransport adapts a single full-duplex WebSocket connection per
+// logical stream to the Transport interface. Unlike pollTransport, it
+// doesn't poll: reads and writes both ride the one connection, so there's
+// no ~15ms idle-flush penalty and no extra GET/POST round trip per chunk of
+// data. Use it against CDNs that support the WS upgrade; fall back to
+// pollTransport (via RouteMux.HandleDefault) for ones that don't.
+type websocketTransport struct {
+	url    string
+	dialer *websocket.Dialer
+}
+
+// NewWebSocketTransport builds a Transport that opens one WebSocket
+// connection per RoundTripStream call against wsURL (a ws:// or wss://
+// URL), tagging each connection with the same X-HTTPConn headers
+// pollTransport uses so the proxy can associate it with the right
+// destination. A nil dialer uses websocket.DefaultDialer.
+func NewWebSocketTransport(wsURL string, dialer *websocket.Dialer) Transport {
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	return &websocketTransport{url: wsURL, dialer: dialer}
+}
+
+func (t *websocketTransport) RoundTripStream(ctx context.Context, connID string, dest string) (io.ReadWriteCloser, error) {
+	header := http.Header{}
+	header.Set(X_HTTPCONN_ID, connID)
+	header.Set(X_HTTPCONN_DEST_ADDR, dest)
+
+	conn, resp, err := t.dialer.DialContext(ctx, t.url, header)
+	if err != nil {
+		return nil, fmt.Errorf("enproxy: dialing websocket transport: %w", err)
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return &websocketStream{conn: conn}, nil
+}
+
+// websocketStream adapts a *websocket.Conn's message-oriented Read/Write to
+// io.ReadWriteCloser's byte-stream semantics, buffering the undelivered
+// remainder of an inbound message across Read calls the way pollStream does
+// across GETs.
+type websocketStream struct {
+	conn *websocket.Conn
+	buf  []byte
+}
+
+func (s *websocketStream) Read(b []byte) (int, error) {
+	for len(s.buf) == 0 {
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		s.buf = msg
+	}
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *websocketStream) Write(b []byte) (int, error) {
+	if err := s.conn.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (s *websocketStream) Close() error {
+	return s.conn.Close()
+}